@@ -0,0 +1,170 @@
+/*
+Copyright 2022 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package proto holds the client/server wire types shared by the auth
+// service API.
+//
+// STOPGAP: in the full Teleport repo, this package is generated by
+// protoc-gen-go from authservice.proto, and MFAAuthenticateResponse and its
+// oneof variants are real protobuf messages sent over gRPC, with generated
+// Marshal/Unmarshal/Size methods and wire tags. This checkout doesn't carry
+// the .proto IDL or a protoc toolchain to regenerate from, so the types
+// below are hand-written, JSON-tagged plain structs good enough to compile
+// and unit-test lib/web/mfajson against, but NOT wire-compatible with the
+// real generated proto.MFAAuthenticateResponse. Adding TOTP/SSO/Headless
+// for real requires extending authservice.proto's MFAAuthenticateResponse
+// oneof and regenerating, not hand-authoring more types here.
+package proto
+
+import "github.com/gravitational/teleport/api/types/webauthn"
+
+// U2FResponse is a response to an MFA challenge signed with a U2F key.
+type U2FResponse struct {
+	// KeyHandle is the base64url-encoded key handle of the U2F device.
+	KeyHandle string `json:"keyHandle"`
+	// SignatureData is the base64url-encoded signature produced by the
+	// device.
+	SignatureData string `json:"signatureData"`
+	// ClientData is the base64url-encoded client data signed by the device.
+	ClientData string `json:"clientData"`
+}
+
+// TOTPResponse is a response to an MFA challenge carrying a time-based
+// one-time code entered by the user.
+type TOTPResponse struct {
+	// Code is the one-time code, typically 6 digits.
+	Code string `json:"code"`
+}
+
+// SSOResponse is a response to an MFA challenge satisfied via an SSO
+// provider, carrying the signed assertion bound to the request that issued
+// the challenge.
+type SSOResponse struct {
+	// RequestId is the ID of the MFA request the assertion is for.
+	RequestId string `json:"requestId"`
+	// Token is the signed assertion token returned by the SSO provider.
+	Token string `json:"token"`
+}
+
+// HeadlessResponse is a response to a headless login MFA challenge, carrying
+// the token issued once the pending request was approved.
+type HeadlessResponse struct {
+	// ApprovalToken is the token issued when the headless request was
+	// approved.
+	ApprovalToken string `json:"approvalToken"`
+}
+
+// MFAAuthenticateResponse is a response to an MFA authentication challenge,
+// carrying exactly one of the supported response variants.
+type MFAAuthenticateResponse struct {
+	// Response holds the concrete MFA response variant.
+	//
+	// Types that are valid to be assigned to Response:
+	//	*MFAAuthenticateResponse_U2F
+	//	*MFAAuthenticateResponse_Webauthn
+	//	*MFAAuthenticateResponse_TOTP
+	//	*MFAAuthenticateResponse_SSO
+	//	*MFAAuthenticateResponse_Headless
+	Response isMFAAuthenticateResponse_Response
+}
+
+// isMFAAuthenticateResponse_Response is the oneof interface implemented by
+// each MFAAuthenticateResponse response variant.
+type isMFAAuthenticateResponse_Response interface {
+	isMFAAuthenticateResponse_Response()
+}
+
+// MFAAuthenticateResponse_U2F is the U2F MFAAuthenticateResponse variant.
+type MFAAuthenticateResponse_U2F struct {
+	U2F *U2FResponse
+}
+
+func (*MFAAuthenticateResponse_U2F) isMFAAuthenticateResponse_Response() {}
+
+// MFAAuthenticateResponse_Webauthn is the webauthn MFAAuthenticateResponse
+// variant.
+type MFAAuthenticateResponse_Webauthn struct {
+	Webauthn *webauthn.CredentialAssertionResponse
+}
+
+func (*MFAAuthenticateResponse_Webauthn) isMFAAuthenticateResponse_Response() {}
+
+// MFAAuthenticateResponse_TOTP is the TOTP MFAAuthenticateResponse variant.
+type MFAAuthenticateResponse_TOTP struct {
+	TOTP *TOTPResponse
+}
+
+func (*MFAAuthenticateResponse_TOTP) isMFAAuthenticateResponse_Response() {}
+
+// MFAAuthenticateResponse_SSO is the SSO MFAAuthenticateResponse variant.
+type MFAAuthenticateResponse_SSO struct {
+	SSO *SSOResponse
+}
+
+func (*MFAAuthenticateResponse_SSO) isMFAAuthenticateResponse_Response() {}
+
+// MFAAuthenticateResponse_Headless is the headless MFAAuthenticateResponse
+// variant.
+type MFAAuthenticateResponse_Headless struct {
+	Headless *HeadlessResponse
+}
+
+func (*MFAAuthenticateResponse_Headless) isMFAAuthenticateResponse_Response() {}
+
+// GetU2F returns the U2F response, or nil if Response holds a different
+// variant.
+func (m *MFAAuthenticateResponse) GetU2F() *U2FResponse {
+	if r, ok := m.Response.(*MFAAuthenticateResponse_U2F); ok {
+		return r.U2F
+	}
+	return nil
+}
+
+// GetWebauthn returns the webauthn response, or nil if Response holds a
+// different variant.
+func (m *MFAAuthenticateResponse) GetWebauthn() *webauthn.CredentialAssertionResponse {
+	if r, ok := m.Response.(*MFAAuthenticateResponse_Webauthn); ok {
+		return r.Webauthn
+	}
+	return nil
+}
+
+// GetTOTP returns the TOTP response, or nil if Response holds a different
+// variant.
+func (m *MFAAuthenticateResponse) GetTOTP() *TOTPResponse {
+	if r, ok := m.Response.(*MFAAuthenticateResponse_TOTP); ok {
+		return r.TOTP
+	}
+	return nil
+}
+
+// GetSSO returns the SSO response, or nil if Response holds a different
+// variant.
+func (m *MFAAuthenticateResponse) GetSSO() *SSOResponse {
+	if r, ok := m.Response.(*MFAAuthenticateResponse_SSO); ok {
+		return r.SSO
+	}
+	return nil
+}
+
+// GetHeadless returns the headless response, or nil if Response holds a
+// different variant.
+func (m *MFAAuthenticateResponse) GetHeadless() *HeadlessResponse {
+	if r, ok := m.Response.(*MFAAuthenticateResponse_Headless); ok {
+		return r.Headless
+	}
+	return nil
+}
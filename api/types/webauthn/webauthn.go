@@ -0,0 +1,58 @@
+/*
+Copyright 2022 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webauthn holds the wire types for WebAuthn credential assertions,
+// mirroring the shape of the JSON produced by a browser's
+// navigator.credentials.get() call.
+package webauthn
+
+// CredentialAssertionResponse is a WebAuthn assertion response, as returned
+// by navigator.credentials.get() and forwarded to the server for
+// verification.
+type CredentialAssertionResponse struct {
+	// ID is the base64url-encoded credential ID.
+	ID string `json:"id"`
+	// RawID is the base64url-encoded raw credential ID.
+	RawID string `json:"rawId"`
+	// Type is the credential type, typically "public-key".
+	Type string `json:"type"`
+	// Extensions holds the client extension results, if any were requested.
+	Extensions *AuthenticationExtensionsClientOutputs `json:"extensions,omitempty"`
+	// AssertionResponse holds the authenticator's signed assertion.
+	AssertionResponse AuthenticatorAssertionResponse `json:"response"`
+}
+
+// AuthenticatorAssertionResponse is the authenticator's response to a
+// WebAuthn assertion request, all base64url-encoded as produced by the
+// browser.
+type AuthenticatorAssertionResponse struct {
+	// ClientDataJSON is the base64url-encoded client data JSON.
+	ClientDataJSON string `json:"clientDataJSON"`
+	// AuthenticatorData is the base64url-encoded authenticator data.
+	AuthenticatorData string `json:"authenticatorData"`
+	// Signature is the base64url-encoded assertion signature.
+	Signature string `json:"signature"`
+	// UserHandle is the base64url-encoded user handle, if the authenticator
+	// returned one.
+	UserHandle string `json:"userHandle,omitempty"`
+}
+
+// AuthenticationExtensionsClientOutputs holds the client extension results
+// returned alongside a WebAuthn assertion.
+type AuthenticationExtensionsClientOutputs struct {
+	// AppID indicates whether the U2F AppID extension was used.
+	AppID bool `json:"appid,omitempty"`
+}
@@ -0,0 +1,37 @@
+/*
+Copyright 2022 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package defaults holds global constants shared across the Teleport
+// codebase.
+package defaults
+
+// Websocket*Challenge are the type discriminators sent alongside an MFA
+// challenge response over a websocket/TDP stream, identifying which
+// MFAAuthenticateResponse variant the payload should be decoded as.
+const (
+	// WebsocketWebauthnChallenge indicates the response is a webauthn
+	// assertion.
+	WebsocketWebauthnChallenge = "n"
+	// WebsocketU2FChallenge indicates the response is a U2F sign response.
+	WebsocketU2FChallenge = "u"
+	// WebsocketTOTPChallenge indicates the response is a TOTP code.
+	WebsocketTOTPChallenge = "t"
+	// WebsocketSSOChallenge indicates the response is an SSO MFA assertion.
+	WebsocketSSOChallenge = "s"
+	// WebsocketHeadlessChallenge indicates the response is a headless
+	// login approval.
+	WebsocketHeadlessChallenge = "h"
+)
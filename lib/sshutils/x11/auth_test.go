@@ -22,12 +22,165 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/gravitational/trace"
 	"github.com/stretchr/testify/require"
 )
 
-func TestXAuthCommands(t *testing.T) {
+// TestXAuthCommands_Native exercises the pure-Go Xauthority backend.
+// Unlike the subprocess-backed variant below, it needs no system xauth
+// binary and so runs unconditionally.
+func TestXAuthCommands_Native(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	xauthFile := filepath.Join(tmpDir, ".Xauthority")
+	display := Display{DisplayNumber: 0}
+
+	// New xauth file should have no entries.
+	xauth := newXAuthCommand(ctx, xauthFile, XAuthBackendNative)
+	xauthEntry, err := xauth.ReadEntry(display)
+	require.Error(t, err)
+	require.True(t, trace.IsNotFound(err))
+	require.Nil(t, xauthEntry)
+
+	// Add trusted xauth entry.
+	trustedXauthEntry, err := NewFakeXAuthEntry(display)
+	require.NoError(t, err)
+	xauth = newXAuthCommand(ctx, xauthFile, XAuthBackendNative)
+	err = xauth.AddEntry(*trustedXauthEntry)
+	require.NoError(t, err)
+
+	// Read back the xauth entry.
+	xauth = newXAuthCommand(ctx, xauthFile, XAuthBackendNative)
+	xauthEntry, err = xauth.ReadEntry(display)
+	require.NoError(t, err)
+	require.Equal(t, trustedXauthEntry, xauthEntry)
+
+	// Remove xauth entries.
+	xauth = newXAuthCommand(ctx, xauthFile, XAuthBackendNative)
+	err = xauth.RemoveEntries(xauthEntry.Display)
+	require.NoError(t, err)
+
+	xauth = newXAuthCommand(ctx, xauthFile, XAuthBackendNative)
+	xauthEntry, err = xauth.ReadEntry(display)
+	require.Error(t, err)
+	require.True(t, trace.IsNotFound(err))
+	require.Nil(t, xauthEntry)
+}
+
+// TestXAuthFile_PreservesForeignFamilyEntries checks that an entry written
+// under a family this backend doesn't itself use (e.g. FamilyInternet, with
+// a binary address rather than a hostname string) survives AddEntry and
+// RemoveEntries untouched, rather than being reinterpreted as a local-family
+// hostname entry and corrupted.
+func TestXAuthFile_PreservesForeignFamilyEntries(t *testing.T) {
+	t.Parallel()
+
+	const familyInternet uint16 = 0
+	foreignAddr := []byte{127, 0, 0, 1}
+
+	xauthFile := filepath.Join(t.TempDir(), ".Xauthority")
+	require.NoError(t, writeXAuthFile(xauthFile, []XAuthEntry{
+		{
+			Display:    Display{DisplayNumber: 1},
+			Proto:      mitMagicCookieProto,
+			Cookie:     "00112233445566778899aabbccddeeff",
+			rawFamily:  familyInternet,
+			rawAddr:    foreignAddr,
+			hasRawAddr: true,
+		},
+	}))
+
+	// Adding an unrelated entry for a different display should leave the
+	// foreign-family entry's on-disk bytes unchanged.
+	newEntry, err := NewFakeXAuthEntry(Display{DisplayNumber: 2})
+	require.NoError(t, err)
+	xauth := newXAuthCommand(context.Background(), xauthFile, XAuthBackendNative)
+	require.NoError(t, xauth.AddEntry(*newEntry))
+
+	entries, err := readXAuthFile(xauthFile)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	var foreign *XAuthEntry
+	for i, e := range entries {
+		if e.Display.DisplayNumber == 1 {
+			foreign = &entries[i]
+		}
+	}
+	require.NotNil(t, foreign, "foreign-family entry should have survived round-tripping")
+	require.True(t, foreign.hasRawAddr)
+	require.Equal(t, familyInternet, foreign.rawFamily)
+	require.Equal(t, foreignAddr, foreign.rawAddr)
+	require.Empty(t, foreign.Display.HostName)
+}
+
+// fakeXAuthCommand is a stub XAuthCommand used to observe whether
+// nativeXAuth delegates GenerateUntrustedCookie to its fallback.
+type fakeXAuthCommand struct {
+	called     bool
+	gotTimeout time.Duration
+}
+
+func (f *fakeXAuthCommand) ReadEntry(display Display) (*XAuthEntry, error) {
+	return nil, trace.NotFound("not implemented")
+}
+func (f *fakeXAuthCommand) AddEntry(entry XAuthEntry) error     { return nil }
+func (f *fakeXAuthCommand) RemoveEntries(display Display) error { return nil }
+func (f *fakeXAuthCommand) GenerateUntrustedCookie(display Display, timeout time.Duration) error {
+	f.called = true
+	f.gotTimeout = timeout
+	return nil
+}
+
+// TestNativeXAuth_GenerateUntrustedCookie checks that the native backend
+// only delegates to the subprocess fallback when a non-zero timeout is
+// requested, generating the cookie itself otherwise.
+func TestNativeXAuth_GenerateUntrustedCookie(t *testing.T) {
+	t.Parallel()
+
+	display := Display{DisplayNumber: 0}
+
+	t.Run("zero timeout is generated natively", func(t *testing.T) {
+		fallback := &fakeXAuthCommand{}
+		x := &nativeXAuth{
+			ctx:       context.Background(),
+			xauthFile: filepath.Join(t.TempDir(), ".Xauthority"),
+			fallback:  fallback,
+		}
+
+		err := x.GenerateUntrustedCookie(display, 0)
+		require.NoError(t, err)
+		require.False(t, fallback.called)
+
+		entry, err := x.ReadEntry(display)
+		require.NoError(t, err)
+		require.Equal(t, mitMagicCookieProto, entry.Proto)
+	})
+
+	t.Run("non-zero timeout falls back to subprocess", func(t *testing.T) {
+		fallback := &fakeXAuthCommand{}
+		x := &nativeXAuth{
+			ctx:       context.Background(),
+			xauthFile: filepath.Join(t.TempDir(), ".Xauthority"),
+			fallback:  fallback,
+		}
+
+		err := x.GenerateUntrustedCookie(display, 5*time.Second)
+		require.NoError(t, err)
+		require.True(t, fallback.called)
+		require.Equal(t, 5*time.Second, fallback.gotTimeout)
+	})
+}
+
+// TestXAuthCommands_Subprocess exercises the system-xauth-backed command,
+// the same way TestXAuthCommands did before the native backend was added.
+// It requires a real xauth binary and X server, so it stays behind the
+// existing env guard.
+func TestXAuthCommands_Subprocess(t *testing.T) {
 	if os.Getenv("TELEPORT_XAUTH_TEST") == "" {
 		t.Skip("Skipping test as xauth is not enabled")
 	}
@@ -49,7 +202,7 @@ func TestXAuthCommands(t *testing.T) {
 	}()
 
 	// New xauth file should have no entries
-	xauth := NewXAuthCommand(ctx, xauthFile)
+	xauth := newXAuthCommand(ctx, xauthFile, XAuthBackendSubprocess)
 	xauthEntry, err := xauth.ReadEntry(display)
 	require.Error(t, err)
 	require.True(t, trace.IsNotFound(err))
@@ -58,29 +211,29 @@ func TestXAuthCommands(t *testing.T) {
 	// Add trusted xauth entry
 	trustedXauthEntry, err := NewFakeXAuthEntry(display)
 	require.NoError(t, err)
-	xauth = NewXAuthCommand(ctx, xauthFile)
+	xauth = newXAuthCommand(ctx, xauthFile, XAuthBackendSubprocess)
 	err = xauth.AddEntry(*trustedXauthEntry)
 	require.NoError(t, err)
 
 	// Read back the xauth entry
-	xauth = NewXAuthCommand(ctx, xauthFile)
+	xauth = newXAuthCommand(ctx, xauthFile, XAuthBackendSubprocess)
 	xauthEntry, err = xauth.ReadEntry(display)
 	require.NoError(t, err)
 	require.Equal(t, trustedXauthEntry, xauthEntry)
 
 	// Remove xauth entries
-	xauth = NewXAuthCommand(ctx, xauthFile)
+	xauth = newXAuthCommand(ctx, xauthFile, XAuthBackendSubprocess)
 	err = xauth.RemoveEntries(xauthEntry.Display)
 	require.NoError(t, err)
 
-	xauth = NewXAuthCommand(ctx, xauthFile)
+	xauth = newXAuthCommand(ctx, xauthFile, XAuthBackendSubprocess)
 	xauthEntry, err = xauth.ReadEntry(display)
 	require.Error(t, err)
 	require.True(t, trace.IsNotFound(err))
 	require.Nil(t, xauthEntry)
 
 	// Generate untrusted xauth entry
-	xauth = NewXAuthCommand(ctx, xauthFile)
+	xauth = newXAuthCommand(ctx, xauthFile, XAuthBackendSubprocess)
 	err = xauth.GenerateUntrustedCookie(display, 0)
 	require.Error(t, err)
 	// TODO(Joerger): xauth generate requires an actual XServer listener
@@ -133,7 +286,77 @@ func TestReadAndRewriteXAuthPacket(t *testing.T) {
 	})
 }
 
-// mockXAuthPacket creates an xauth packet for the given xauth entry.
+// TestReadAndRewriteXAuthPacket_XDMAuthorization1 round-trips an
+// XDM-AUTHORIZATION-1 auth packet the same way TestReadAndRewriteXAuthPacket
+// does for MIT-MAGIC-COOKIE-1, and checks that mutating the authenticator
+// after encryption is detected and rejected.
+func TestReadAndRewriteXAuthPacket_XDMAuthorization1(t *testing.T) {
+	t.Parallel()
+
+	realEntry, err := newFakeXAuthEntry(Display{}, xdmAuthorizationProtocol{})
+	require.NoError(t, err)
+	realKey, err := hex.DecodeString(realEntry.Cookie)
+	require.NoError(t, err)
+
+	spoofedEntry, err := realEntry.SpoofXAuthEntry()
+	require.NoError(t, err)
+	spoofedKey, err := hex.DecodeString(spoofedEntry.Cookie)
+	require.NoError(t, err)
+
+	authenticator := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("match and replace xauth packet", func(t *testing.T) {
+		clientPayload := mockXDMAuthData(t, spoofedKey, authenticator)
+		in := bytes.NewBuffer(mockXAuthPacket(t, &XAuthEntry{Proto: spoofedEntry.Proto, Cookie: hex.EncodeToString(clientPayload)}))
+
+		out, err := ReadAndRewriteXAuthPacket(in, spoofedEntry, realEntry)
+		require.NoError(t, err)
+
+		// The rewritten packet's header and proto name must be unchanged,
+		// and its auth data must decrypt correctly under the real key with
+		// the same authenticator.
+		const xClientInitHeaderSize = 12
+		headerAndProto := xClientInitHeaderSize + len(pad([]byte(realEntry.Proto)))
+		require.Equal(t, append(mockXAuthPacketInitial(len(realEntry.Proto), xdmAuthDataSize), pad([]byte(realEntry.Proto))...), out[:headerAndProto])
+
+		gotAuthData := out[headerAndProto : headerAndProto+xdmAuthDataSize]
+		plaintext, err := xdmDecryptBlock(realKey, gotAuthData[xdmAuthenticatorSize:])
+		require.NoError(t, err)
+		require.NoError(t, xdmVerifyAuthenticator(gotAuthData[:xdmAuthenticatorSize], plaintext))
+	})
+
+	t.Run("mutated authenticator is rejected", func(t *testing.T) {
+		clientPayload := mockXDMAuthData(t, spoofedKey, authenticator)
+		// Flip a bit in the authenticator after encryption, as an
+		// on-the-wire attacker would.
+		clientPayload[0] ^= 0xff
+
+		in := bytes.NewBuffer(mockXAuthPacket(t, &XAuthEntry{Proto: spoofedEntry.Proto, Cookie: hex.EncodeToString(clientPayload)}))
+		out, err := ReadAndRewriteXAuthPacket(in, spoofedEntry, realEntry)
+		require.True(t, trace.IsAccessDenied(err), "got error %v", err)
+		require.Empty(t, out)
+	})
+}
+
+// mockXDMAuthData builds a valid XDM-AUTHORIZATION-1 wire auth data packet
+// (authenticator prefix + encrypted block) for the given key and
+// authenticator.
+func mockXDMAuthData(t *testing.T, key, authenticator []byte) []byte {
+	plaintext := make([]byte, xdmEncryptedBlockSize)
+	copy(plaintext[6:8], xdmChecksum(authenticator))
+
+	encrypted, err := xdmEncryptBlock(key, plaintext)
+	require.NoError(t, err)
+
+	out := make([]byte, 0, xdmAuthDataSize)
+	out = append(out, authenticator...)
+	out = append(out, encrypted...)
+	return out
+}
+
+// mockXAuthPacket creates an xauth packet for the given xauth entry,
+// padding the proto name and auth data out to 4-byte boundaries as the X11
+// protocol requires.
 func mockXAuthPacket(t *testing.T, entry *XAuthEntry) []byte {
 	authData, err := hex.DecodeString(entry.Cookie)
 	require.NoError(t, err)
@@ -141,9 +364,8 @@ func mockXAuthPacket(t *testing.T, entry *XAuthEntry) []byte {
 	var xauthPacket []byte
 	initPacket := mockXAuthPacketInitial(len(entry.Proto), len(authData))
 	xauthPacket = append(xauthPacket, initPacket...)
-	xauthPacket = append(xauthPacket, []byte(entry.Proto)...)
-	xauthPacket = append(xauthPacket, 0, 0)
-	xauthPacket = append(xauthPacket, authData...)
+	xauthPacket = append(xauthPacket, pad([]byte(entry.Proto))...)
+	xauthPacket = append(xauthPacket, pad(authData)...)
 	return xauthPacket
 }
 
@@ -156,4 +378,4 @@ func mockXAuthPacketInitial(authProtoLen, authDataLen int) []byte {
 	e.PutUint16(initData[6:8], uint16(authProtoLen))
 	e.PutUint16(initData[8:10], uint16(authDataLen))
 	return initData
-}
\ No newline at end of file
+}
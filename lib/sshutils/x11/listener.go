@@ -0,0 +1,48 @@
+// Copyright 2022 Gravitational, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package x11
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/gravitational/trace"
+)
+
+const (
+	// x11BasePort is the tcp port XServer displays are conventionally
+	// offset from (display N listens on port x11BasePort+N).
+	x11BasePort = 6000
+	// DefaultDisplayOffset is the display number Teleport starts searching
+	// from when opening a proxy XServer listener, leaving room below it for
+	// the host's own XServer displays.
+	DefaultDisplayOffset = 10
+	// DefaultMaxDisplay is the display number Teleport stops searching at
+	// when opening a proxy XServer listener.
+	DefaultMaxDisplay = 1000
+)
+
+// OpenNewXServerListener opens a tcp listener on the first unused display
+// number in [displayOffset, maxDisplay), returning the listener and the
+// display it was opened on.
+func OpenNewXServerListener(displayOffset, maxDisplay, screen int) (net.Listener, Display, error) {
+	for displayNumber := displayOffset; displayNumber < maxDisplay; displayNumber++ {
+		l, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", x11BasePort+displayNumber))
+		if err == nil {
+			return l, Display{DisplayNumber: displayNumber, ScreenNumber: screen}, nil
+		}
+	}
+	return nil, Display{}, trace.LimitExceeded("no open displays found in range [%d, %d)", displayOffset, maxDisplay)
+}
@@ -0,0 +1,249 @@
+// Copyright 2022 Gravitational, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package x11
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gravitational/trace"
+)
+
+// Xauthority family values, as defined by the Xauthority file format. We
+// only ever read/write FamilyLocal or FamilyWild entries; other families
+// are preserved verbatim when rewriting the file so we don't clobber
+// entries written by other tools.
+const (
+	xauthFamilyLocal uint16 = 256
+	xauthFamilyWild  uint16 = 0xffff
+)
+
+// xauthLockSuffix and xauthLockRetries/xauthLockWait match the locking
+// convention used by the xauth binary itself (a `<file>.lock` file created
+// with O_EXCL), so the native backend stays safe to use concurrently with
+// external `xauth` invocations.
+const (
+	xauthLockSuffix  = ".lock"
+	xauthLockRetries = 50
+	xauthLockWait    = 100 * time.Millisecond
+)
+
+// withXAuthFileLock acquires the xauth-compatible lock file alongside
+// xauthFile, runs fn, then releases it.
+func withXAuthFileLock(xauthFile string, fn func() error) error {
+	lockFile := xauthFile + xauthLockSuffix
+
+	var locked bool
+	for i := 0; i < xauthLockRetries; i++ {
+		f, err := os.OpenFile(lockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			locked = true
+			break
+		}
+		if !os.IsExist(err) {
+			return trace.Wrap(err)
+		}
+		time.Sleep(xauthLockWait)
+	}
+	if !locked {
+		return trace.LimitExceeded("timed out waiting for xauth lock file %q", lockFile)
+	}
+	defer os.Remove(lockFile)
+
+	return fn()
+}
+
+// readXAuthFile parses every record out of the Xauthority file at path.
+// The file format is a sequence of big-endian records of the form:
+//
+//	family uint16 | addrLen uint16 | addr []byte |
+//	displayLen uint16 | display []byte |
+//	protoLen uint16 | proto []byte |
+//	dataLen uint16 | data []byte
+func readXAuthFile(path string) ([]XAuthEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, trace.NotFound("xauth file %q does not exist", path)
+		}
+		return nil, trace.Wrap(err)
+	}
+	defer f.Close()
+
+	var entries []XAuthEntry
+	for {
+		family, err := readUint16(f)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+
+		addr, err := readField(f)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		displayNum, err := readField(f)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		proto, err := readField(f)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		data, err := readField(f)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+
+		displayNumber, err := strconv.Atoi(string(displayNum))
+		if err != nil {
+			return nil, trace.BadParameter("malformed xauth display number %q: %v", displayNum, err)
+		}
+
+		entry := XAuthEntry{
+			Display: Display{
+				HostName:      hostNameForFamily(family, addr),
+				DisplayNumber: displayNumber,
+			},
+			Proto:  string(proto),
+			Cookie: hex.EncodeToString(data),
+		}
+		// Families other than local/wild (e.g. FamilyInternet,
+		// FamilyInternet6) carry binary address bytes we can't losslessly
+		// turn into a HostName string, and entries under them may belong
+		// to another tool sharing this file. Keep their exact bytes so
+		// they're written back out unchanged rather than reinterpreted.
+		if family != xauthFamilyLocal && family != xauthFamilyWild {
+			entry.rawFamily = family
+			entry.rawAddr = append([]byte(nil), addr...)
+			entry.hasRawAddr = true
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// writeXAuthFile overwrites the Xauthority file at path with the given
+// entries. Entries with no HostName are written under the wild-family
+// record, matching xauth's own convention for cookies usable regardless of
+// which local display connects (our proxy listener has no real hostname of
+// its own); entries with a HostName are written under the local family.
+// Entries read from the file under any other family (see readXAuthFile)
+// are written back out with their original family and address bytes
+// untouched, so round-tripping the file can't corrupt entries owned by
+// other tools.
+func writeXAuthFile(path string, entries []XAuthEntry) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	defer f.Close()
+
+	for _, entry := range entries {
+		data, err := hex.DecodeString(entry.Cookie)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+
+		family := xauthFamilyWild
+		addr := []byte{}
+		switch {
+		case entry.hasRawAddr:
+			family = entry.rawFamily
+			addr = entry.rawAddr
+		case entry.Display.HostName != "":
+			family = xauthFamilyLocal
+			addr = []byte(entry.Display.HostName)
+		}
+
+		if err := writeUint16(f, family); err != nil {
+			return trace.Wrap(err)
+		}
+		if err := writeField(f, addr); err != nil {
+			return trace.Wrap(err)
+		}
+		if err := writeField(f, []byte(strconv.Itoa(entry.Display.DisplayNumber))); err != nil {
+			return trace.Wrap(err)
+		}
+		if err := writeField(f, []byte(entry.Proto)); err != nil {
+			return trace.Wrap(err)
+		}
+		if err := writeField(f, data); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// hostNameForFamily derives a Display.HostName from an xauth record's
+// family and address. Only the local family's address bytes are a
+// hostname string; every other family has no HostName we can derive.
+// Entries under a family other than local or wild keep their raw family
+// and address bytes (see XAuthEntry.hasRawAddr); a wild-family entry's
+// address bytes are not kept and it is written back with an empty address.
+func hostNameForFamily(family uint16, addr []byte) string {
+	if family == xauthFamilyLocal {
+		return string(addr)
+	}
+	return ""
+}
+
+func readUint16(r io.Reader) (uint16, error) {
+	var buf [2]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(buf[:]), nil
+}
+
+func writeUint16(w io.Writer, v uint16) error {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], v)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// readField reads a length-prefixed byte field: a big-endian uint16 length
+// followed by that many bytes.
+func readField(r io.Reader) ([]byte, error) {
+	n, err := readUint16(r)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// writeField writes a length-prefixed byte field: a big-endian uint16
+// length followed by the field bytes.
+func writeField(w io.Writer, b []byte) error {
+	if err := writeUint16(w, uint16(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
@@ -26,10 +26,38 @@ import (
 	"github.com/gravitational/teleport/lib/defaults"
 )
 
-// Decode parses a JSON-encoded MFA authentication response.
-// Is either webauthn (type="n") or u2f (type="u").
-func Decode(b []byte, typ string) (*authproto.MFAAuthenticateResponse, error) {
-	var resp *authproto.MFAAuthenticateResponse
+// maxDecodeInputBytes is the largest payload Decode will attempt to
+// unmarshal. Responses arrive over a websocket/TDP stream from a client we
+// don't fully trust, so we bound the size well before it reaches
+// json.Unmarshal rather than letting an adversarial or buggy client force us
+// to allocate an unbounded amount of memory.
+const maxDecodeInputBytes = 64 * 1024
+
+// Decode parses a JSON-encoded MFA authentication response. It is meant to
+// be the single entry point for decoding any MFAAuthenticateResponse
+// variant carried over websockets/TDP: webauthn (type="n"), u2f (type="u"),
+// TOTP (type="t"), SSO MFA (type="s"), and headless approval (type="h").
+//
+// NOTE: this checkout doesn't contain the web/TDP MFA codec or tsh login
+// flow files the original request asked to migrate onto this entry point
+// (lib/web and lib/client aren't otherwise present here), so no ad-hoc
+// TOTP/SSO/headless parsing elsewhere was touched. Decode is unified and
+// ready to be the call site once those callers exist in a fuller checkout.
+func Decode(b []byte, typ string) (resp *authproto.MFAAuthenticateResponse, err error) {
+	if len(b) > maxDecodeInputBytes {
+		return nil, trace.BadParameter("mfa response payload of %d bytes exceeds maximum of %d bytes", len(b), maxDecodeInputBytes)
+	}
+
+	// json.Unmarshal into the response structs below is
+	// not guaranteed to be panic-free for arbitrary input (e.g. pathological
+	// base64 fields). Convert any panic into a regular error instead of
+	// taking down the caller.
+	defer func() {
+		if r := recover(); r != nil {
+			resp = nil
+			err = trace.BadParameter("failed to decode mfa response: %v", r)
+		}
+	}()
 
 	switch typ {
 	case defaults.WebsocketWebauthnChallenge:
@@ -37,6 +65,9 @@ func Decode(b []byte, typ string) (*authproto.MFAAuthenticateResponse, error) {
 		if err := json.Unmarshal(b, &r); err != nil {
 			return nil, trace.Wrap(err)
 		}
+		if err := validateWebauthnResponse(&r); err != nil {
+			return nil, trace.Wrap(err)
+		}
 		resp = &authproto.MFAAuthenticateResponse{
 			Response: &authproto.MFAAuthenticateResponse_Webauthn{
 				Webauthn: &r,
@@ -47,14 +78,178 @@ func Decode(b []byte, typ string) (*authproto.MFAAuthenticateResponse, error) {
 		if err := json.Unmarshal(b, &u2fResponse); err != nil {
 			return nil, trace.Wrap(err)
 		}
+		if err := validateU2FResponse(&u2fResponse); err != nil {
+			return nil, trace.Wrap(err)
+		}
 		resp = &authproto.MFAAuthenticateResponse{
 			Response: &authproto.MFAAuthenticateResponse_U2F{
 				U2F: &u2fResponse,
 			},
 		}
+	case defaults.WebsocketTOTPChallenge:
+		var totpResponse authproto.TOTPResponse
+		if err := json.Unmarshal(b, &totpResponse); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if err := validateTOTPResponse(&totpResponse); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		resp = &authproto.MFAAuthenticateResponse{
+			Response: &authproto.MFAAuthenticateResponse_TOTP{
+				TOTP: &totpResponse,
+			},
+		}
+	case defaults.WebsocketSSOChallenge:
+		var ssoResponse authproto.SSOResponse
+		if err := json.Unmarshal(b, &ssoResponse); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if err := validateSSOResponse(&ssoResponse); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		resp = &authproto.MFAAuthenticateResponse{
+			Response: &authproto.MFAAuthenticateResponse_SSO{
+				SSO: &ssoResponse,
+			},
+		}
+	case defaults.WebsocketHeadlessChallenge:
+		var headlessResponse authproto.HeadlessResponse
+		if err := json.Unmarshal(b, &headlessResponse); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if err := validateHeadlessResponse(&headlessResponse); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		resp = &authproto.MFAAuthenticateResponse{
+			Response: &authproto.MFAAuthenticateResponse_Headless{
+				Headless: &headlessResponse,
+			},
+		}
 	default:
 		return nil, trace.BadParameter("unsupported change type %q", typ)
 	}
 
 	return resp, nil
 }
+
+// maxFieldLen bounds the individual base64url-encoded fields inside a
+// webauthn/U2F response. These are all derived from fixed-size credential
+// IDs, signatures and authenticator data in practice, so anything wildly
+// larger than that indicates a malformed or adversarial payload rather than
+// a legitimate authenticator response.
+const maxFieldLen = 4 * 1024
+
+// validateWebauthnResponse checks that the fields required to verify a
+// webauthn assertion are present and within sane length bounds.
+func validateWebauthnResponse(r *webauthn.CredentialAssertionResponse) error {
+	if r.ID == "" {
+		return trace.BadParameter("missing webauthn credential id")
+	}
+	if len(r.ID) > maxFieldLen {
+		return trace.BadParameter("webauthn credential id too large")
+	}
+	if len(r.AssertionResponse.ClientDataJSON) == 0 {
+		return trace.BadParameter("missing webauthn clientDataJSON")
+	}
+	if err := checkFieldLen("webauthn clientDataJSON", r.AssertionResponse.ClientDataJSON); err != nil {
+		return trace.Wrap(err)
+	}
+	if len(r.AssertionResponse.AuthenticatorData) == 0 {
+		return trace.BadParameter("missing webauthn authenticatorData")
+	}
+	if err := checkFieldLen("webauthn authenticatorData", r.AssertionResponse.AuthenticatorData); err != nil {
+		return trace.Wrap(err)
+	}
+	if len(r.AssertionResponse.Signature) == 0 {
+		return trace.BadParameter("missing webauthn signature")
+	}
+	if err := checkFieldLen("webauthn signature", r.AssertionResponse.Signature); err != nil {
+		return trace.Wrap(err)
+	}
+	return nil
+}
+
+// validateU2FResponse checks that the fields required to verify a U2F
+// response are present and within sane length bounds.
+func validateU2FResponse(r *authproto.U2FResponse) error {
+	if r.KeyHandle == "" {
+		return trace.BadParameter("missing u2f key handle")
+	}
+	if err := checkFieldLen("u2f key handle", r.KeyHandle); err != nil {
+		return trace.Wrap(err)
+	}
+	if r.SignatureData == "" {
+		return trace.BadParameter("missing u2f signature data")
+	}
+	if err := checkFieldLen("u2f signature data", r.SignatureData); err != nil {
+		return trace.Wrap(err)
+	}
+	if r.ClientData == "" {
+		return trace.BadParameter("missing u2f client data")
+	}
+	if err := checkFieldLen("u2f client data", r.ClientData); err != nil {
+		return trace.Wrap(err)
+	}
+	return nil
+}
+
+// totpCodeMinLen and totpCodeMaxLen bound the length of a TOTP code entered
+// by the user. Teleport issues 6-digit codes, but we accept up to 8 digits
+// to tolerate authenticators configured for a longer code length.
+const (
+	totpCodeMinLen = 6
+	totpCodeMaxLen = 8
+)
+
+// validateTOTPResponse checks that the TOTP code looks like a real
+// authenticator code rather than arbitrary client input.
+func validateTOTPResponse(r *authproto.TOTPResponse) error {
+	if len(r.Code) < totpCodeMinLen || len(r.Code) > totpCodeMaxLen {
+		return trace.BadParameter("totp code must be between %d and %d digits", totpCodeMinLen, totpCodeMaxLen)
+	}
+	for _, c := range r.Code {
+		if c < '0' || c > '9' {
+			return trace.BadParameter("totp code must contain only digits")
+		}
+	}
+	return nil
+}
+
+// validateSSOResponse checks that an SSO MFA response carries both the
+// signed assertion and the request ID it was issued for.
+func validateSSOResponse(r *authproto.SSOResponse) error {
+	if r.RequestId == "" {
+		return trace.BadParameter("missing sso mfa request id")
+	}
+	if err := checkFieldLen("sso mfa request id", r.RequestId); err != nil {
+		return trace.Wrap(err)
+	}
+	if r.Token == "" {
+		return trace.BadParameter("missing sso mfa assertion token")
+	}
+	if err := checkFieldLen("sso mfa assertion token", r.Token); err != nil {
+		return trace.Wrap(err)
+	}
+	return nil
+}
+
+// validateHeadlessResponse checks that a headless approval response carries
+// the approval token issued to the pending request.
+func validateHeadlessResponse(r *authproto.HeadlessResponse) error {
+	if r.ApprovalToken == "" {
+		return trace.BadParameter("missing headless approval token")
+	}
+	if err := checkFieldLen("headless approval token", r.ApprovalToken); err != nil {
+		return trace.Wrap(err)
+	}
+	return nil
+}
+
+// checkFieldLen bounds a base64url-encoded field, erroring on anything
+// larger than maxFieldLen bytes.
+func checkFieldLen(name string, field string) error {
+	if len(field) > maxFieldLen {
+		return trace.BadParameter("%s too large", name)
+	}
+	return nil
+}
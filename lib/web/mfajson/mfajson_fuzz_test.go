@@ -0,0 +1,105 @@
+/*
+Copyright 2022 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mfajson
+
+import (
+	"testing"
+
+	"github.com/gravitational/teleport/lib/defaults"
+)
+
+// webauthnSeedCorpus holds realistic webauthn assertion responses used to
+// seed FuzzDecode, derived from the shape of a real browser webauthn.get()
+// result.
+var webauthnSeedCorpus = []string{
+	`{"id":"AQIDBAUGBwg","rawId":"AQIDBAUGBwg","type":"public-key","extensions":{"appid":true},"response":{"authenticatorData":"SZYN5YgOjGh0NBcPZHZgW4_krrmihjLHmVzzuoMdl2MFAAAAAQ","clientDataJSON":"eyJ0eXBlIjoid2ViYXV0aG4uZ2V0In0","signature":"MEUCIQ","userHandle":""}}`,
+	`{"id":"","rawId":"","type":"public-key","response":{"authenticatorData":"","clientDataJSON":"","signature":""}}`,
+}
+
+// u2fSeedCorpus holds realistic U2F sign responses used to seed FuzzDecode.
+var u2fSeedCorpus = []string{
+	`{"keyHandle":"AQIDBAUGBwg","signatureData":"MEUCIQ","clientData":"eyJ0eXAiOiJuYXZpZ2F0b3IuaWQuZ2V0QXNzZXJ0aW9uIn0"}`,
+	`{"keyHandle":"","signatureData":"","clientData":""}`,
+}
+
+// FuzzDecodeWebauthn exercises Decode under the webauthn ("n") type
+// discriminator, seeded with realistic webauthn assertion responses. It
+// must never panic, and should only ever return trace.BadParameter or a
+// json.Unmarshal error. It is built as the fuzz_mfajson_decode_webauthn
+// oss-fuzz target; see oss-fuzz-build.sh.
+func FuzzDecodeWebauthn(f *testing.F) {
+	for _, seed := range webauthnSeedCorpus {
+		f.Add([]byte(seed))
+	}
+	f.Add([]byte(`not json`))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		_, _ = Decode(b, defaults.WebsocketWebauthnChallenge)
+	})
+}
+
+// FuzzDecodeU2F exercises Decode under the U2F ("u") type discriminator,
+// seeded with realistic U2F sign responses. It must never panic, and
+// should only ever return trace.BadParameter or a json.Unmarshal error.
+// It is built as the fuzz_mfajson_decode_u2f oss-fuzz target; see
+// oss-fuzz-build.sh.
+func FuzzDecodeU2F(f *testing.F) {
+	for _, seed := range u2fSeedCorpus {
+		f.Add([]byte(seed))
+	}
+	f.Add([]byte(`not json`))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		_, _ = Decode(b, defaults.WebsocketU2FChallenge)
+	})
+}
+
+// FuzzDecode exercises Decode with arbitrary bytes and an arbitrary type
+// discriminator, covering the TOTP, SSO, and headless response types in
+// addition to webauthn and U2F. Inputs whose discriminator is not one of
+// those five are skipped without calling Decode. It must never panic, and
+// should only ever return trace.BadParameter or a json.Unmarshal error.
+func FuzzDecode(f *testing.F) {
+	for _, seed := range webauthnSeedCorpus {
+		f.Add([]byte(seed), defaults.WebsocketWebauthnChallenge)
+	}
+	for _, seed := range u2fSeedCorpus {
+		f.Add([]byte(seed), defaults.WebsocketU2FChallenge)
+	}
+	f.Add([]byte(`{"code":"123456"}`), defaults.WebsocketTOTPChallenge)
+	f.Add([]byte(`{"requestId":"req-1","token":"tok"}`), defaults.WebsocketSSOChallenge)
+	f.Add([]byte(`{"approvalToken":"tok-1"}`), defaults.WebsocketHeadlessChallenge)
+	f.Add([]byte(`not json`), defaults.WebsocketWebauthnChallenge)
+	f.Add([]byte(`not json`), defaults.WebsocketU2FChallenge)
+
+	validTypes := map[string]bool{
+		defaults.WebsocketWebauthnChallenge: true,
+		defaults.WebsocketU2FChallenge:      true,
+		defaults.WebsocketTOTPChallenge:     true,
+		defaults.WebsocketSSOChallenge:      true,
+		defaults.WebsocketHeadlessChallenge: true,
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte, typ string) {
+		if !validTypes[typ] {
+			t.Skip("unsupported discriminator, Decode is expected to reject it cheaply")
+		}
+		// Decode must never panic on arbitrary input; any error it returns
+		// is fine.
+		_, _ = Decode(b, typ)
+	})
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2022 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mfajson
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gravitational/teleport/lib/defaults"
+)
+
+func TestDecode_InputTooLarge(t *testing.T) {
+	oversized := make([]byte, maxDecodeInputBytes+1)
+	_, err := Decode(oversized, defaults.WebsocketWebauthnChallenge)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "exceeds maximum")
+}
+
+func TestDecode_UnsupportedType(t *testing.T) {
+	_, err := Decode([]byte(`{}`), "bogus")
+	require.Error(t, err)
+}
+
+func TestDecode_Webauthn(t *testing.T) {
+	tests := []struct {
+		desc    string
+		body    string
+		wantErr string
+	}{
+		{
+			desc:    "missing credential id",
+			body:    `{"response":{"clientDataJSON":"AAAA","authenticatorData":"AAAA","signature":"AAAA"}}`,
+			wantErr: "missing webauthn credential id",
+		},
+		{
+			desc:    "missing clientDataJSON",
+			body:    `{"id":"abc","response":{"authenticatorData":"AAAA","signature":"AAAA"}}`,
+			wantErr: "missing webauthn clientDataJSON",
+		},
+		{
+			desc:    "missing authenticatorData",
+			body:    `{"id":"abc","response":{"clientDataJSON":"AAAA","signature":"AAAA"}}`,
+			wantErr: "missing webauthn authenticatorData",
+		},
+		{
+			desc:    "missing signature",
+			body:    `{"id":"abc","response":{"clientDataJSON":"AAAA","authenticatorData":"AAAA"}}`,
+			wantErr: "missing webauthn signature",
+		},
+		{
+			desc:    "malformed json",
+			body:    `{"id": `,
+			wantErr: "unexpected end of JSON input",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := Decode([]byte(tt.body), defaults.WebsocketWebauthnChallenge)
+			require.Error(t, err)
+			require.True(t, strings.Contains(err.Error(), tt.wantErr), "got error %q", err)
+		})
+	}
+}
+
+func TestDecode_TOTP(t *testing.T) {
+	tests := []struct {
+		desc    string
+		body    string
+		wantErr string
+	}{
+		{
+			desc:    "code too short",
+			body:    `{"code":"123"}`,
+			wantErr: "must be between",
+		},
+		{
+			desc:    "code too long",
+			body:    `{"code":"123456789"}`,
+			wantErr: "must be between",
+		},
+		{
+			desc:    "non-digit code",
+			body:    `{"code":"12345a"}`,
+			wantErr: "must contain only digits",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := Decode([]byte(tt.body), defaults.WebsocketTOTPChallenge)
+			require.Error(t, err)
+			require.True(t, strings.Contains(err.Error(), tt.wantErr), "got error %q", err)
+		})
+	}
+
+	resp, err := Decode([]byte(`{"code":"123456"}`), defaults.WebsocketTOTPChallenge)
+	require.NoError(t, err)
+	require.Equal(t, "123456", resp.GetTOTP().Code)
+}
+
+func TestDecode_SSO(t *testing.T) {
+	tests := []struct {
+		desc    string
+		body    string
+		wantErr string
+	}{
+		{
+			desc:    "missing request id",
+			body:    `{"token":"abc"}`,
+			wantErr: "missing sso mfa request id",
+		},
+		{
+			desc:    "missing token",
+			body:    `{"requestId":"req-1"}`,
+			wantErr: "missing sso mfa assertion token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := Decode([]byte(tt.body), defaults.WebsocketSSOChallenge)
+			require.Error(t, err)
+			require.True(t, strings.Contains(err.Error(), tt.wantErr), "got error %q", err)
+		})
+	}
+}
+
+func TestDecode_Headless(t *testing.T) {
+	_, err := Decode([]byte(`{}`), defaults.WebsocketHeadlessChallenge)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "missing headless approval token")
+
+	resp, err := Decode([]byte(`{"approvalToken":"tok-1"}`), defaults.WebsocketHeadlessChallenge)
+	require.NoError(t, err)
+	require.Equal(t, "tok-1", resp.GetHeadless().ApprovalToken)
+}
+
+func TestDecode_U2F(t *testing.T) {
+	tests := []struct {
+		desc    string
+		body    string
+		wantErr string
+	}{
+		{
+			desc:    "missing key handle",
+			body:    `{"signatureData":"AAAA","clientData":"AAAA"}`,
+			wantErr: "missing u2f key handle",
+		},
+		{
+			desc:    "missing signature data",
+			body:    `{"keyHandle":"AAAA","clientData":"AAAA"}`,
+			wantErr: "missing u2f signature data",
+		},
+		{
+			desc:    "missing client data",
+			body:    `{"keyHandle":"AAAA","signatureData":"AAAA"}`,
+			wantErr: "missing u2f client data",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := Decode([]byte(tt.body), defaults.WebsocketU2FChallenge)
+			require.Error(t, err)
+			require.True(t, strings.Contains(err.Error(), tt.wantErr), "got error %q", err)
+		})
+	}
+}